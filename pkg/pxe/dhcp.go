@@ -0,0 +1,241 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pxe
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/u-root/u-root/pkg/dhclient"
+)
+
+const (
+	// optionPXEPathPrefix is the ProxyDHCP "PXE path prefix" option used
+	// by some PXE servers to point netboot clients at a config file
+	// directly, rather than making them derive one from the bootfile
+	// name.
+	optionPXEPathPrefix = 210
+
+	// optionPXEConfigFile is the ProxyDHCP "PXE configuration file"
+	// option, either at the top level or nested inside the vendor-class
+	// encapsulated options below.
+	optionPXEConfigFile = 209
+
+	// optionVendorSpecificInformation is BIOS PXE's option 43: a set of
+	// vendor-class encapsulated sub-options.
+	optionVendorSpecificInformation = 43
+
+	// optionIPXEEncapsulated is iPXE's option 175, used the same way as
+	// option 43 by iPXE clients.
+	optionIPXEEncapsulated = 175
+)
+
+// Lease is the networking configuration negotiated by BootFromDHCP.
+type Lease struct {
+	IP      net.IP
+	Mask    net.IPMask
+	Gateway net.IP
+	DNS     []net.IP
+}
+
+// BootFromDHCP sends DHCPv4 and DHCPv6 requests on iface at the same time,
+// derives the boot configuration URL from the first reply that yields one,
+// and hands it to the same Schemes/Parsers machinery Boot uses.
+//
+// The URL is derived, in order of preference, from: the ProxyDHCP path
+// prefix or config file option (210/209, either top-level or nested inside
+// the vendor-class encapsulated options 43/175), and the bootfile name
+// (option 67, or DHCPv6's OPT_BOOTFILE_URL) resolved against the
+// next-server (option 66, falling back to the DHCP siaddr).
+func BootFromDHCP(ctx context.Context, iface string) (*BootEntry, *Lease, error) {
+	ifc, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, nil, fmt.Errorf("pxe: %v", err)
+	}
+
+	results := dhclient.SendRequests(ctx, []net.Interface{*ifc}, true /* ipv4 */, true /* ipv6 */, 10*time.Second, 3)
+
+	var lastErr error
+	for result := range results {
+		if result.Err != nil {
+			lastErr = result.Err
+			continue
+		}
+
+		var (
+			cfgURL *url.URL
+			lease  *Lease
+			err    error
+		)
+		switch p := result.Lease.(type) {
+		case *dhcpv4.DHCPv4:
+			lease = &Lease{
+				IP:      p.YourIPAddr,
+				Mask:    p.SubnetMask(),
+				Gateway: firstIP(p.Router()),
+				DNS:     p.DNS(),
+			}
+			cfgURL, err = bootConfigURLv4(p)
+
+		case *dhcpv6.Message:
+			lease = &Lease{DNS: p.Options.DNS()}
+			cfgURL, err = bootConfigURLv6(p)
+
+		default:
+			continue
+		}
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		entry, err := fetchBootEntry(cfgURL)
+		if err != nil {
+			return nil, lease, err
+		}
+		return entry, lease, nil
+	}
+
+	if lastErr != nil {
+		return nil, nil, fmt.Errorf("pxe: dhcp discover on %s: %v", iface, lastErr)
+	}
+	return nil, nil, fmt.Errorf("pxe: dhcp discover on %s: no DHCP server responded", iface)
+}
+
+// fetchBootEntry fetches and parses the config file at cfgURL using
+// DefaultSchemes/DefaultParsers.
+func fetchBootEntry(cfgURL *url.URL) (*BootEntry, error) {
+	r, err := DefaultSchemes.GetFile(cfgURL)
+	if err != nil {
+		return nil, err
+	}
+	parser, err := DefaultParsers.ParserFor(cfgURL, r)
+	if err != nil {
+		return nil, err
+	}
+	wd := *cfgURL
+	wd.Path = path.Dir(cfgURL.Path)
+	entries, err := parser.Parse(r, &wd, DefaultSchemes)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("pxe: %v contained no boot entries", cfgURL)
+	}
+	return &entries[0], nil
+}
+
+// bootConfigURLv4 derives the initial boot configuration URL from a DHCPv4
+// offer.
+func bootConfigURLv4(p *dhcpv4.DHCPv4) (*url.URL, error) {
+	prefix := pxePathPrefix(
+		p.Options.Get(dhcpv4.GenericOptionCode(optionPXEPathPrefix)),
+		p.Options.Get(dhcpv4.GenericOptionCode(optionPXEConfigFile)),
+		p.Options.Get(dhcpv4.GenericOptionCode(optionVendorSpecificInformation)),
+		p.Options.Get(dhcpv4.GenericOptionCode(optionIPXEEncapsulated)),
+	)
+	if len(prefix) != 0 {
+		return resolveBootURLv4(p, prefix)
+	}
+
+	bootfile := p.BootFileName
+	if len(bootfile) == 0 {
+		return nil, fmt.Errorf("pxe: DHCP offer had no bootfile name (option 67)")
+	}
+	return resolveBootURLv4(p, bootfile)
+}
+
+// resolveBootURLv4 turns raw -- a bootfile name or PXE path prefix taken
+// from a DHCPv4 offer -- into a usable URL. iPXE and some PXE ROMs hand
+// back a fully qualified URL directly; everything else is a bare path and
+// must be resolved against the next-server (option 66, falling back to the
+// DHCP siaddr) over TFTP.
+func resolveBootURLv4(p *dhcpv4.DHCPv4, raw string) (*url.URL, error) {
+	if u, err := url.Parse(raw); err == nil && len(u.Scheme) != 0 {
+		return u, nil
+	}
+
+	server := p.ServerIPAddr.String()
+	if nextServer := p.Options.Get(dhcpv4.OptionTFTPServerName); len(nextServer) != 0 {
+		server = string(nextServer)
+	}
+	return &url.URL{Scheme: "tftp", Host: server, Path: "/" + strings.TrimPrefix(raw, "/")}, nil
+}
+
+// bootConfigURLv6 derives the initial boot configuration URL from a DHCPv6
+// reply's OPT_BOOTFILE_URL (RFC 5970).
+func bootConfigURLv6(p *dhcpv6.Message) (*url.URL, error) {
+	bootfileURL := p.Options.BootFileURL()
+	if len(bootfileURL) == 0 {
+		return nil, fmt.Errorf("pxe: DHCPv6 reply had no bootfile URL (OPT_BOOTFILE_URL)")
+	}
+	return url.Parse(bootfileURL)
+}
+
+// pxePathPrefix picks a path prefix/config file override out of the
+// top-level PXE path-prefix (210) and config-file (209) options, falling
+// back to the same sub-options nested inside the vendor-class encapsulated
+// options (43 for BIOS PXE clients, 175 for iPXE clients).
+func pxePathPrefix(topPrefix, topConfigFile, vendorOpts, ipxeOpts []byte) string {
+	if len(topPrefix) != 0 {
+		return string(topPrefix)
+	}
+	if len(topConfigFile) != 0 {
+		return string(topConfigFile)
+	}
+
+	for _, encapsulated := range [][]byte{vendorOpts, ipxeOpts} {
+		sub := parseEncapsulatedOptions(encapsulated)
+		if v, ok := sub[optionPXEPathPrefix]; ok {
+			return string(v)
+		}
+		if v, ok := sub[optionPXEConfigFile]; ok {
+			return string(v)
+		}
+	}
+	return ""
+}
+
+// parseEncapsulatedOptions decodes a DHCP "encapsulated vendor options"
+// byte string (option 43's or option 175's value) into its sub-options,
+// keyed by sub-option code. Malformed input yields whatever sub-options
+// were parsed before the error.
+func parseEncapsulatedOptions(b []byte) map[byte][]byte {
+	opts := make(map[byte][]byte)
+	for len(b) > 0 {
+		code := b[0]
+		if code == 0xff { // end
+			break
+		}
+		if code == 0x00 { // pad
+			b = b[1:]
+			continue
+		}
+		if len(b) < 2 {
+			break
+		}
+		n := int(b[1])
+		if len(b) < 2+n {
+			break
+		}
+		opts[code] = b[2 : 2+n]
+		b = b[2+n:]
+	}
+	return opts
+}
+
+func firstIP(ips []net.IP) net.IP {
+	if len(ips) == 0 {
+		return nil
+	}
+	return ips[0]
+}
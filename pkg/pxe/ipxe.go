@@ -0,0 +1,140 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pxe
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// IPXEParser parses iPXE scripts: text files beginning with the "#!ipxe"
+// shebang.
+//
+// Only a small, commonly used subset of the iPXE scripting language is
+// supported: "kernel", "initrd", "imgargs", "chain", "set", and "${var}"
+// variable substitution. Unrecognized directives (echo, prompt, sleep, ...)
+// are ignored rather than rejected, since they don't affect the resulting
+// BootEntry.
+type IPXEParser struct{}
+
+var ipxeVarRE = regexp.MustCompile(`\$\{([a-zA-Z0-9_]+)\}`)
+
+// maxChainDepth bounds how many "chain" directives Parse will follow before
+// giving up, so a script that chains to itself (or a cycle across two or
+// more scripts) can't recurse forever.
+const maxChainDepth = 8
+
+// Parse implements Parser.
+func (p IPXEParser) Parse(r io.ReaderAt, wd *url.URL, s Schemes) ([]BootEntry, error) {
+	return p.parse(r, wd, s, 0)
+}
+
+func (p IPXEParser) parse(r io.ReaderAt, wd *url.URL, s Schemes, depth int) ([]BootEntry, error) {
+	if depth > maxChainDepth {
+		return nil, fmt.Errorf("ipxe: chain depth exceeded %d, possible cycle", maxChainDepth)
+	}
+
+	sc := bufio.NewScanner(io.NewSectionReader(r, 0, math.MaxInt64))
+
+	if !sc.Scan() {
+		return nil, fmt.Errorf("ipxe: empty script")
+	}
+	if !strings.HasPrefix(strings.TrimSpace(sc.Text()), "#!ipxe") {
+		return nil, fmt.Errorf("ipxe: missing #!ipxe shebang")
+	}
+
+	env := map[string]string{
+		"mac":  "",
+		"ip":   "",
+		"uuid": "",
+	}
+	var entry BootEntry
+
+	for sc.Scan() {
+		line := strings.TrimSpace(expandVars(sc.Text(), env))
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		switch fields[0] {
+		case "set":
+			if len(fields) < 3 {
+				return nil, fmt.Errorf("ipxe: malformed set directive: %q", line)
+			}
+			env[fields[1]] = strings.Join(fields[2:], " ")
+
+		case "kernel":
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("ipxe: malformed kernel directive: %q", line)
+			}
+			u, err := parseURL(fields[1], wd)
+			if err != nil {
+				return nil, err
+			}
+			entry.Kernel = u
+			entry.Cmdline = strings.Join(fields[2:], " ")
+
+		case "imgargs":
+			// imgargs <image> <args...> appends args to the named
+			// image's command line; we only track one kernel's
+			// command line at a time, so just append.
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("ipxe: malformed imgargs directive: %q", line)
+			}
+			entry.Cmdline = strings.TrimSpace(strings.Join([]string{entry.Cmdline, strings.Join(fields[2:], " ")}, " "))
+
+		case "initrd":
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("ipxe: malformed initrd directive: %q", line)
+			}
+			u, err := parseURL(fields[1], wd)
+			if err != nil {
+				return nil, err
+			}
+			entry.Initrd = u
+
+		case "chain":
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("ipxe: malformed chain directive: %q", line)
+			}
+			u, err := parseURL(fields[1], wd)
+			if err != nil {
+				return nil, err
+			}
+			cr, err := s.GetFile(u)
+			if err != nil {
+				return nil, err
+			}
+			// Relative URLs in the chained script resolve against
+			// the directory it was fetched from.
+			cwd := *u
+			cwd.Path = path.Dir(u.Path)
+			return p.parse(cr, &cwd, s, depth+1)
+
+		case "boot":
+			return []BootEntry{entry}, nil
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("ipxe: %v", err)
+	}
+	return []BootEntry{entry}, nil
+}
+
+// expandVars replaces ${var} references in line with their values from env,
+// leaving unknown variables as an empty string.
+func expandVars(line string, env map[string]string) string {
+	return ipxeVarRE.ReplaceAllStringFunc(line, func(m string) string {
+		name := ipxeVarRE.FindStringSubmatch(m)[1]
+		return env[name]
+	})
+}
@@ -0,0 +1,113 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pxe
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestIPXEParserShebang(t *testing.T) {
+	wd := &url.URL{Scheme: "tftp", Host: "192.168.1.1", Path: "/boot"}
+
+	for i, tt := range []struct {
+		script string
+		err    bool
+	}{
+		{script: "#!ipxe\nkernel default\n", err: false},
+		{script: "kernel default\n", err: true},
+		{script: "", err: true},
+	} {
+		t.Run(fmt.Sprintf("Test #%02d", i), func(t *testing.T) {
+			_, err := IPXEParser{}.Parse(strings.NewReader(tt.script), wd, nil)
+			if (err != nil) != tt.err {
+				t.Errorf("Parse() error = %v, wantErr %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestIPXEParserKernelImgargs(t *testing.T) {
+	wd := &url.URL{Scheme: "tftp", Host: "192.168.1.1", Path: "/boot"}
+	script := "#!ipxe\n" +
+		"kernel http://192.168.1.1/vmlinuz root=/dev/sda1\n" +
+		"imgargs vmlinuz console=ttyS0\n" +
+		"initrd http://192.168.1.1/initrd.img\n" +
+		"boot\n"
+
+	entries, err := IPXEParser{}.Parse(strings.NewReader(script), wd, nil)
+	if err != nil {
+		t.Fatalf("Parse() = %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("Parse() = %d entries, want 1", len(entries))
+	}
+
+	e := entries[0]
+	if got, want := e.Kernel.String(), "http://192.168.1.1/vmlinuz"; got != want {
+		t.Errorf("Kernel = %v, want %v", got, want)
+	}
+	if got, want := e.Cmdline, "root=/dev/sda1 console=ttyS0"; got != want {
+		t.Errorf("Cmdline = %q, want %q", got, want)
+	}
+	if got, want := e.Initrd.String(), "http://192.168.1.1/initrd.img"; got != want {
+		t.Errorf("Initrd = %v, want %v", got, want)
+	}
+}
+
+func TestIPXEParserVarExpansion(t *testing.T) {
+	wd := &url.URL{Scheme: "tftp", Host: "192.168.1.1", Path: "/boot"}
+	script := "#!ipxe\n" +
+		"set mac 52:54:00:12:34:56\n" +
+		"kernel http://192.168.1.1/vmlinuz mac=${mac} missing=${nosuch}\n" +
+		"boot\n"
+
+	entries, err := IPXEParser{}.Parse(strings.NewReader(script), wd, nil)
+	if err != nil {
+		t.Fatalf("Parse() = %v", err)
+	}
+
+	want := "mac=52:54:00:12:34:56 missing="
+	if got := entries[0].Cmdline; got != want {
+		t.Errorf("Cmdline = %q, want %q", got, want)
+	}
+}
+
+func TestIPXEParserChain(t *testing.T) {
+	fs := NewMockScheme("tftp")
+	fs.Add("192.168.1.1", "/boot/next.ipxe", "#!ipxe\nkernel http://192.168.1.1/vmlinuz\nboot\n")
+
+	s := make(Schemes)
+	s.Register("tftp", fs)
+
+	wd := &url.URL{Scheme: "tftp", Host: "192.168.1.1", Path: "/boot"}
+	script := "#!ipxe\nchain next.ipxe\n"
+
+	entries, err := IPXEParser{}.Parse(strings.NewReader(script), wd, s)
+	if err != nil {
+		t.Fatalf("Parse() = %v", err)
+	}
+	if got, want := entries[0].Kernel.String(), "http://192.168.1.1/vmlinuz"; got != want {
+		t.Errorf("Kernel = %v, want %v", got, want)
+	}
+}
+
+func TestIPXEParserChainCycle(t *testing.T) {
+	fs := NewMockScheme("tftp")
+	fs.Add("192.168.1.1", "/boot/loop.ipxe", "#!ipxe\nchain loop.ipxe\n")
+
+	s := make(Schemes)
+	s.Register("tftp", fs)
+
+	wd := &url.URL{Scheme: "tftp", Host: "192.168.1.1", Path: "/boot"}
+	script := "#!ipxe\nchain loop.ipxe\n"
+
+	p := IPXEParser{}
+	if _, err := p.Parse(strings.NewReader(script), wd, s); err == nil {
+		t.Fatal("Parse() = nil error, want an error for a chain cycle")
+	}
+}
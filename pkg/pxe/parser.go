@@ -0,0 +1,107 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pxe
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// BootEntry is a boot configuration parsed out of a pxelinux, iPXE, or GRUB
+// config file: a kernel, an optional initrd, and a kernel command line.
+type BootEntry struct {
+	// Name identifies this entry, e.g. a pxelinux LABEL.
+	Name string
+
+	// Kernel is the URL the kernel should be fetched from.
+	Kernel *url.URL
+
+	// Initrd is the URL the initrd should be fetched from, if any.
+	Initrd *url.URL
+
+	// Cmdline is the kernel command line.
+	Cmdline string
+}
+
+// Parser parses a boot configuration file into BootEntries.
+//
+// wd is the working directory: the URL of the directory the configuration
+// file was fetched from, used to resolve any relative URLs it references. s
+// is used to fetch those URLs.
+type Parser interface {
+	Parse(r io.ReaderAt, wd *url.URL, s Schemes) ([]BootEntry, error)
+}
+
+// Parsers is a registry of config Parsers keyed by a name identifying the
+// config flavor, e.g. "pxelinux", "ipxe", or "grub".
+type Parsers map[string]Parser
+
+// DefaultParsers are the Parsers consulted by Boot and BootFromDHCP.
+var DefaultParsers = Parsers{
+	"ipxe": IPXEParser{},
+}
+
+// Register registers `parser` under `name`.
+func (p Parsers) Register(name string, parser Parser) {
+	p[name] = parser
+}
+
+// ParserFor picks a Parser for the config file found at u with contents r,
+// first by u's extension, then by sniffing r's first line for a
+// recognizable magic such as iPXE's "#!ipxe" shebang.
+func (p Parsers) ParserFor(u *url.URL, r io.ReaderAt) (Parser, error) {
+	if ext := path.Ext(u.Path); ext == ".ipxe" {
+		if parser, ok := p["ipxe"]; ok {
+			return parser, nil
+		}
+	} else if ext == ".cfg" && strings.Contains(strings.ToLower(u.Path), "grub") {
+		if parser, ok := p["grub"]; ok {
+			return parser, nil
+		}
+	}
+
+	var buf [512]byte
+	n, err := r.ReadAt(buf[:], 0)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("sniffing %v: %v", u, err)
+	}
+	firstLine := strings.SplitN(string(buf[:n]), "\n", 2)[0]
+	if strings.HasPrefix(strings.TrimSpace(firstLine), "#!ipxe") {
+		if parser, ok := p["ipxe"]; ok {
+			return parser, nil
+		}
+	}
+
+	if parser, ok := p["pxelinux"]; ok {
+		return parser, nil
+	}
+	return nil, fmt.Errorf("no parser registered for %v", u)
+}
+
+// Boot fetches the config file at rawurl using DefaultSchemes, picks a
+// Parser for it from DefaultParsers, and returns the resulting boot
+// entries.
+func Boot(rawurl string) ([]BootEntry, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := DefaultSchemes.GetFile(u)
+	if err != nil {
+		return nil, err
+	}
+
+	parser, err := DefaultParsers.ParserFor(u, r)
+	if err != nil {
+		return nil, err
+	}
+	wd := *u
+	wd.Path = path.Dir(u.Path)
+	return parser.Parse(r, &wd, DefaultSchemes)
+}
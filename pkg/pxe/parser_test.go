@@ -0,0 +1,46 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pxe
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParserForExtension(t *testing.T) {
+	parsers := Parsers{"ipxe": IPXEParser{}}
+
+	u := &url.URL{Scheme: "tftp", Host: "192.168.1.1", Path: "/boot/default.ipxe"}
+	got, err := parsers.ParserFor(u, strings.NewReader("not sniffed, extension wins\n"))
+	if err != nil {
+		t.Fatalf("ParserFor() = %v", err)
+	}
+	if _, ok := got.(IPXEParser); !ok {
+		t.Errorf("ParserFor() = %T, want IPXEParser", got)
+	}
+}
+
+func TestParserForSniff(t *testing.T) {
+	parsers := Parsers{"ipxe": IPXEParser{}}
+
+	u := &url.URL{Scheme: "tftp", Host: "192.168.1.1", Path: "/boot/default"}
+	got, err := parsers.ParserFor(u, strings.NewReader("#!ipxe\nkernel foo\n"))
+	if err != nil {
+		t.Fatalf("ParserFor() = %v", err)
+	}
+	if _, ok := got.(IPXEParser); !ok {
+		t.Errorf("ParserFor() = %T, want IPXEParser", got)
+	}
+}
+
+func TestParserForNoMatch(t *testing.T) {
+	parsers := Parsers{"ipxe": IPXEParser{}}
+
+	u := &url.URL{Scheme: "tftp", Host: "192.168.1.1", Path: "/boot/default"}
+	if _, err := parsers.ParserFor(u, strings.NewReader("DEFAULT menu\nLABEL linux\n")); err == nil {
+		t.Error("ParserFor() = nil error, want error for unrecognized config")
+	}
+}
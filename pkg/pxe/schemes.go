@@ -0,0 +1,100 @@
+// Copyright 2017-2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pxe
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+)
+
+// ErrNoSuchScheme is returned by Schemes.GetFile and LazyGetFile when no
+// Scheme is registered for a URL's scheme.
+var ErrNoSuchScheme = errors.New("no such scheme")
+
+// URLError is an error fetching a file at a URL.
+type URLError struct {
+	URL *url.URL
+	Err error
+}
+
+// Error implements error.
+func (u *URLError) Error() string {
+	return fmt.Sprintf("%v: %v", u.URL, u.Err)
+}
+
+// Scheme implements a method to fetch files for a particular URL scheme,
+// e.g. "tftp" or "http".
+type Scheme interface {
+	// GetFile fetches the file at u. Implementations that can defer the
+	// actual I/O should do so until the returned io.ReaderAt is read, so
+	// that callers that only need some boot entries don't pay for
+	// fetching files they never use.
+	GetFile(u *url.URL) (io.ReaderAt, error)
+}
+
+// Schemes is a map of scheme name -> Scheme implementation.
+//
+// This exists so config, kernel, and initrd URLs can be resolved to actual
+// bytes regardless of which scheme (tftp, http, ...) they use.
+type Schemes map[string]Scheme
+
+// Register registers the Scheme implementation `s` for URLs with scheme
+// `scheme`.
+func (s Schemes) Register(scheme string, i Scheme) {
+	s[scheme] = i
+}
+
+// GetFile fetches the file referenced by u, using the Scheme registered for
+// u.Scheme.
+func (s Schemes) GetFile(u *url.URL) (io.ReaderAt, error) {
+	scheme, ok := s[u.Scheme]
+	if !ok {
+		return nil, &URLError{URL: u, Err: ErrNoSuchScheme}
+	}
+
+	r, err := scheme.GetFile(u)
+	if err != nil {
+		return nil, &URLError{URL: u, Err: err}
+	}
+	return r, nil
+}
+
+// LazyGetFile does exactly what GetFile does. Whether the file's contents
+// are fetched before the returned io.ReaderAt is read depends on the Scheme
+// implementation, as described on Scheme.GetFile.
+func (s Schemes) LazyGetFile(u *url.URL) (io.ReaderAt, error) {
+	return s.GetFile(u)
+}
+
+// DefaultSchemes are the Schemes consulted by Boot and BootFromDHCP. Callers
+// register concrete Scheme implementations (e.g. tftp, http) into it.
+var DefaultSchemes = make(Schemes)
+
+// parseURL parses a (possibly relative) URL `surl`.
+//
+// If `surl` does not specify a scheme, it is resolved relative to the
+// working directory `wd`: scheme and host are taken from wd, and the path
+// is resolved relative to wd's path.
+func parseURL(surl string, wd *url.URL) (*url.URL, error) {
+	u, err := url.Parse(surl)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(u.Scheme) == 0 {
+		if wd == nil {
+			return nil, fmt.Errorf("no scheme in url %q, and no working directory to resolve it against", surl)
+		}
+		u.Scheme = wd.Scheme
+		u.Host = wd.Host
+		if !path.IsAbs(u.Path) {
+			u.Path = path.Join(wd.Path, u.Path)
+		}
+	}
+	return u, nil
+}
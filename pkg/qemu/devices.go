@@ -7,6 +7,7 @@ package qemu
 import (
 	"fmt"
 	"net"
+	"strings"
 	"sync/atomic"
 )
 
@@ -57,6 +58,142 @@ func (n *Network) Cmdline() []string {
 	return args
 }
 
+// Forward is a single host<->guest port forward for a UserNetwork Device.
+type Forward struct {
+	// Proto is "tcp" or "udp". Defaults to "tcp" if empty.
+	Proto string
+
+	// HostAddr is the host address to listen on. Defaults to 127.0.0.1.
+	HostAddr string
+
+	// HostPort is the host port to forward from. GuestForward fills this
+	// in with a free port when it is left at 0.
+	HostPort int
+
+	// GuestPort is the port inside the guest that HostPort forwards to.
+	GuestPort int
+}
+
+// UserNetwork is a Device that connects a VM to the network using QEMU's
+// user-mode (SLIRP) networking.
+//
+// Unlike Network, UserNetwork does not require another VM or a listening
+// host socket to talk to -- QEMU emulates a small NAT'd network entirely in
+// userspace. This is the simplest way to reach a single VM's SSH or HTTP
+// server from the host, via Forwards.
+type UserNetwork struct {
+	// ID is the netdev id. Defaults to "net0" if empty.
+	ID string
+
+	// MAC is the virtualized NIC's MAC address. A locally administered
+	// address is generated if left nil.
+	MAC net.HardwareAddr
+
+	// Forwards are the host<->guest port forwards to set up.
+	Forwards []Forward
+
+	// Hostname is the guest-visible hostname QEMU answers built-in DHCP
+	// requests with.
+	Hostname string
+
+	// DNS overrides the DNS server address handed out by the built-in
+	// DHCP server.
+	DNS net.IP
+}
+
+// NewUserNetwork returns an empty UserNetwork with no forwards set up.
+func NewUserNetwork() *UserNetwork {
+	return &UserNetwork{ID: "net0"}
+}
+
+// GuestForward reserves a free host port for f (if f.HostPort is 0, by
+// briefly listening on it and closing the listener) and appends f to the
+// UserNetwork's Forwards.
+//
+// Reserving the port this way -- rather than letting QEMU pick one -- lets
+// callers learn the chosen port before the VM has even started, and avoids
+// collisions when many VMs are started in parallel.
+//
+// The reservation is made in the same address family as f.Proto, so a udp
+// forward's port is picked from the UDP port space rather than TCP's.
+func (u *UserNetwork) GuestForward(f Forward) error {
+	if f.HostPort == 0 {
+		host := f.HostAddr
+		if len(host) == 0 {
+			host = "127.0.0.1"
+		}
+
+		proto := f.Proto
+		if len(proto) == 0 {
+			proto = "tcp"
+		}
+
+		switch proto {
+		case "tcp":
+			l, err := net.Listen("tcp", fmt.Sprintf("%s:0", host))
+			if err != nil {
+				return fmt.Errorf("failed to reserve a host port for forward: %v", err)
+			}
+			f.HostPort = l.Addr().(*net.TCPAddr).Port
+			l.Close()
+
+		case "udp":
+			l, err := net.ListenPacket("udp", fmt.Sprintf("%s:0", host))
+			if err != nil {
+				return fmt.Errorf("failed to reserve a host port for forward: %v", err)
+			}
+			f.HostPort = l.LocalAddr().(*net.UDPAddr).Port
+			l.Close()
+
+		default:
+			return fmt.Errorf("GuestForward: unsupported protocol %q, must be tcp or udp", proto)
+		}
+	}
+	u.Forwards = append(u.Forwards, f)
+	return nil
+}
+
+// Cmdline implements Device.
+func (u *UserNetwork) Cmdline() []string {
+	if u == nil {
+		return nil
+	}
+
+	id := u.ID
+	if len(id) == 0 {
+		id = "net0"
+	}
+
+	netdev := []string{"user", fmt.Sprintf("id=%s", id)}
+	for _, f := range u.Forwards {
+		proto := f.Proto
+		if len(proto) == 0 {
+			proto = "tcp"
+		}
+		hostAddr := f.HostAddr
+		if len(hostAddr) == 0 {
+			hostAddr = "127.0.0.1"
+		}
+		netdev = append(netdev, fmt.Sprintf("hostfwd=%s:%s:%d-:%d", proto, hostAddr, f.HostPort, f.GuestPort))
+	}
+	if len(u.Hostname) != 0 {
+		netdev = append(netdev, fmt.Sprintf("hostname=%s", u.Hostname))
+	}
+	if u.DNS != nil {
+		netdev = append(netdev, fmt.Sprintf("dns=%s", u.DNS))
+	}
+
+	mac := u.MAC
+	if len(mac) == 0 {
+		mac = net.HardwareAddr{0x0e, 0x00, 0x00, 0x00, 0x00, 0x01}
+	}
+
+	return []string{
+		"-netdev", strings.Join(netdev, ","),
+		"-device", fmt.Sprintf("virtio-net-pci,netdev=%s,mac=%s", id, mac),
+	}
+}
+
 // ReadOnlyDirectory is a Device that exposes a directory as a /dev/sda1
 // readonly vfat partition in the VM.
 type ReadOnlyDirectory struct {
@@ -0,0 +1,50 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package qemu
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestUserNetworkCmdline(t *testing.T) {
+	u := &UserNetwork{
+		ID:       "net0",
+		MAC:      net.HardwareAddr{0x0e, 0x00, 0x00, 0x00, 0x00, 0x01},
+		Hostname: "vm1",
+	}
+	if err := u.GuestForward(Forward{Proto: "tcp", HostPort: 2222, GuestPort: 22}); err != nil {
+		t.Fatalf("GuestForward() = %v", err)
+	}
+
+	want := []string{
+		"-netdev", "user,id=net0,hostfwd=tcp:127.0.0.1:2222-:22,hostname=vm1",
+		"-device", "virtio-net-pci,netdev=net0,mac=0e:00:00:00:00:01",
+	}
+	if got := u.Cmdline(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Cmdline() = %v, want %v", got, want)
+	}
+}
+
+func TestUserNetworkGuestForwardReservesPort(t *testing.T) {
+	u := &UserNetwork{}
+	if err := u.GuestForward(Forward{Proto: "tcp", GuestPort: 80}); err != nil {
+		t.Fatalf("GuestForward() = %v", err)
+	}
+	if len(u.Forwards) != 1 || u.Forwards[0].HostPort == 0 {
+		t.Errorf("GuestForward() = %+v, want a reserved non-zero HostPort", u.Forwards)
+	}
+}
+
+func TestUserNetworkGuestForwardUDP(t *testing.T) {
+	u := &UserNetwork{}
+	if err := u.GuestForward(Forward{Proto: "udp", GuestPort: 69}); err != nil {
+		t.Fatalf("GuestForward() = %v", err)
+	}
+	if len(u.Forwards) != 1 || u.Forwards[0].HostPort == 0 {
+		t.Errorf("GuestForward() = %+v, want a reserved non-zero HostPort", u.Forwards)
+	}
+}
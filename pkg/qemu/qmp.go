@@ -0,0 +1,255 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package qemu
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// QMPMonitor is a Device that exposes a QEMU Machine Protocol (QMP) monitor
+// for the VM, in addition to whatever else the command line does.
+//
+// QMPMonitor only adds the "-qmp" flag to the command line; call Dial once
+// the VM has started to get a QMPClient to control it.
+type QMPMonitor struct {
+	// Path is the UNIX domain socket path QEMU should listen on. Mutually
+	// exclusive with Addr.
+	Path string
+
+	// Addr is a "host:port" TCP address QEMU should listen on instead of
+	// a UNIX domain socket.
+	Addr string
+}
+
+// NewQMPMonitor returns a QMPMonitor listening on the given UNIX domain
+// socket path.
+func NewQMPMonitor(path string) *QMPMonitor {
+	return &QMPMonitor{Path: path}
+}
+
+// Cmdline implements Device.
+func (m *QMPMonitor) Cmdline() []string {
+	if m == nil {
+		return nil
+	}
+	if len(m.Addr) != 0 {
+		return []string{"-qmp", fmt.Sprintf("tcp:%s,server,nowait", m.Addr)}
+	}
+	return []string{"-qmp", fmt.Sprintf("unix:%s,server,nowait", m.Path)}
+}
+
+// Dial connects to the QMP socket this monitor exposes and performs the
+// qmp_capabilities handshake, after which the returned QMPClient can be used
+// to issue commands and receive events.
+func (m *QMPMonitor) Dial() (*QMPClient, error) {
+	network, addr := "unix", m.Path
+	if len(m.Addr) != 0 {
+		network, addr = "tcp", m.Addr
+	}
+
+	conn, err := net.Dial(network, addr)
+	if err != nil {
+		return nil, fmt.Errorf("qmp: dial %s %s: %v", network, addr, err)
+	}
+
+	c := &QMPClient{
+		conn:    conn,
+		dec:     json.NewDecoder(conn),
+		replies: make(chan qmpResponse, 1),
+		Events:  make(chan QMPEvent, 16),
+	}
+
+	// The server sends a greeting with its version before anything else.
+	var greeting struct {
+		QMP struct {
+			Version json.RawMessage `json:"version"`
+		} `json:"QMP"`
+	}
+	if err := c.dec.Decode(&greeting); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("qmp: reading greeting: %v", err)
+	}
+
+	go c.readLoop()
+
+	if _, err := c.execute("qmp_capabilities", nil); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("qmp: capabilities negotiation: %v", err)
+	}
+	return c, nil
+}
+
+// QMPEvent is an asynchronous event emitted by QEMU over QMP, such as
+// SHUTDOWN, RESET, STOP, or POWERDOWN.
+type QMPEvent struct {
+	Event     string `json:"event"`
+	Timestamp struct {
+		Seconds      int64 `json:"seconds"`
+		Microseconds int64 `json:"microseconds"`
+	} `json:"timestamp"`
+	Data map[string]interface{} `json:"data"`
+}
+
+type qmpCommand struct {
+	Execute   string      `json:"execute"`
+	Arguments interface{} `json:"arguments,omitempty"`
+}
+
+type qmpResponse struct {
+	Return json.RawMessage `json:"return,omitempty"`
+	Error  *qmpError       `json:"error,omitempty"`
+}
+
+type qmpError struct {
+	Class string `json:"class"`
+	Desc  string `json:"desc"`
+}
+
+func (e *qmpError) Error() string {
+	return fmt.Sprintf("qmp: %s: %s", e.Class, e.Desc)
+}
+
+// QMPClient is a connection to a running VM's QMP monitor.
+//
+// Events is fed SHUTDOWN, RESET, STOP, POWERDOWN, and any other event QEMU
+// emits. Callers that don't drain it don't lose command I/O -- readLoop
+// drops events rather than blocking on a full channel -- but they do miss
+// events sent while the channel was full.
+type QMPClient struct {
+	conn    net.Conn
+	dec     *json.Decoder
+	mu      sync.Mutex
+	replies chan qmpResponse
+
+	Events chan QMPEvent
+}
+
+// readLoop demultiplexes the QMP connection into command replies (sent to
+// replies, read by execute) and events (sent to Events).
+//
+// Both channels are closed when the connection drops or the server sends
+// something undecodable, so an execute call blocked on a reply that will
+// never arrive gets woken up with an error instead of hanging forever.
+func (c *QMPClient) readLoop() {
+	defer close(c.Events)
+	defer close(c.replies)
+	for {
+		var raw json.RawMessage
+		if err := c.dec.Decode(&raw); err != nil {
+			return
+		}
+
+		var probe struct {
+			Event string `json:"event"`
+		}
+		if err := json.Unmarshal(raw, &probe); err == nil && len(probe.Event) != 0 {
+			var ev QMPEvent
+			if err := json.Unmarshal(raw, &ev); err == nil {
+				// Never block on Events: an undrained event
+				// stream must not be able to wedge command
+				// replies, which share this read loop.
+				select {
+				case c.Events <- ev:
+				default:
+				}
+			}
+			continue
+		}
+
+		var resp qmpResponse
+		if err := json.Unmarshal(raw, &resp); err != nil {
+			continue
+		}
+		// Never block on replies either: an unsolicited reply-shaped
+		// message with no command waiting for it must not wedge the
+		// read loop.
+		select {
+		case c.replies <- resp:
+		default:
+		}
+	}
+}
+
+// execute sends a QMP command and blocks for its reply. QMP only allows one
+// command in flight at a time, so execute serializes callers.
+func (c *QMPClient) execute(command string, args interface{}) (json.RawMessage, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := json.NewEncoder(c.conn).Encode(qmpCommand{Execute: command, Arguments: args}); err != nil {
+		return nil, fmt.Errorf("qmp: sending %s: %v", command, err)
+	}
+
+	resp, ok := <-c.replies
+	if !ok {
+		return nil, fmt.Errorf("qmp: connection closed while waiting for reply to %s", command)
+	}
+	if resp.Error != nil {
+		return nil, resp.Error
+	}
+	return resp.Return, nil
+}
+
+// Quit asks QEMU to terminate immediately, without shutting down the guest.
+func (c *QMPClient) Quit() error {
+	_, err := c.execute("quit", nil)
+	return err
+}
+
+// SystemReset performs a hard reset of the guest.
+func (c *QMPClient) SystemReset() error {
+	_, err := c.execute("system_reset", nil)
+	return err
+}
+
+// SystemPowerdown requests a graceful shutdown of the guest, as if the power
+// button had been pressed. The guest's OS may ignore it.
+func (c *QMPClient) SystemPowerdown() error {
+	_, err := c.execute("system_powerdown", nil)
+	return err
+}
+
+// HumanMonitorCommand runs a human monitor protocol (HMP) command line and
+// returns its text output.
+func (c *QMPClient) HumanMonitorCommand(cmdline string) (string, error) {
+	raw, err := c.execute("human-monitor-command", map[string]string{
+		"command-line": cmdline,
+	})
+	if err != nil {
+		return "", err
+	}
+	var out string
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return "", fmt.Errorf("qmp: decoding human-monitor-command reply: %v", err)
+	}
+	return out, nil
+}
+
+// DeviceAdd hotplugs a device of the given driver into the running VM. args
+// are additional QOM properties (e.g. "id", "bus", "drive").
+func (c *QMPClient) DeviceAdd(driver string, args map[string]interface{}) error {
+	props := make(map[string]interface{}, len(args)+1)
+	for k, v := range args {
+		props[k] = v
+	}
+	props["driver"] = driver
+
+	_, err := c.execute("device_add", props)
+	return err
+}
+
+// DeviceDel unplugs the device with the given QOM id from the running VM.
+func (c *QMPClient) DeviceDel(id string) error {
+	_, err := c.execute("device_del", map[string]string{"id": id})
+	return err
+}
+
+// Close closes the underlying QMP connection.
+func (c *QMPClient) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,128 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package qemu
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+)
+
+// newTestQMPClient returns a QMPClient wired to an in-memory net.Pipe, along
+// with the other end of the pipe for a test to act as a fake QEMU server on.
+// It skips QMPMonitor.Dial's greeting/capabilities handshake since the pipe
+// has no real QEMU on the other end.
+func newTestQMPClient(t *testing.T) (*QMPClient, net.Conn) {
+	t.Helper()
+	clientConn, serverConn := net.Pipe()
+
+	c := &QMPClient{
+		conn:    clientConn,
+		dec:     json.NewDecoder(clientConn),
+		replies: make(chan qmpResponse, 1),
+		Events:  make(chan QMPEvent, 16),
+	}
+	go c.readLoop()
+	t.Cleanup(func() { clientConn.Close() })
+	return c, serverConn
+}
+
+func TestQMPExecuteSuccess(t *testing.T) {
+	c, server := newTestQMPClient(t)
+	defer server.Close()
+
+	go func() {
+		var cmd qmpCommand
+		json.NewDecoder(server).Decode(&cmd)
+		json.NewEncoder(server).Encode(qmpResponse{Return: json.RawMessage(`{}`)})
+	}()
+
+	if _, err := c.execute("query-status", nil); err != nil {
+		t.Fatalf("execute() = %v", err)
+	}
+}
+
+func TestQMPExecuteError(t *testing.T) {
+	c, server := newTestQMPClient(t)
+	defer server.Close()
+
+	go func() {
+		var cmd qmpCommand
+		json.NewDecoder(server).Decode(&cmd)
+		json.NewEncoder(server).Encode(qmpResponse{Error: &qmpError{Class: "GenericError", Desc: "nope"}})
+	}()
+
+	if _, err := c.execute("quit", nil); err == nil {
+		t.Fatal("execute() = nil error, want an error")
+	}
+}
+
+// TestQMPEventDoesNotBlockExecute floods more events than Events' buffer can
+// hold before the command reply arrives; execute must still complete.
+func TestQMPEventDoesNotBlockExecute(t *testing.T) {
+	c, server := newTestQMPClient(t)
+	defer server.Close()
+
+	go func() {
+		enc := json.NewEncoder(server)
+		for i := 0; i < 32; i++ {
+			enc.Encode(QMPEvent{Event: "STOP"})
+		}
+		var cmd qmpCommand
+		json.NewDecoder(server).Decode(&cmd)
+		enc.Encode(qmpResponse{Return: json.RawMessage(`{}`)})
+	}()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.execute("query-status", nil)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("execute() = %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("execute() blocked on an undrained Events channel")
+	}
+}
+
+// TestQMPExecuteAfterCloseReturnsError checks that severing the connection
+// while a command is in flight wakes execute up with an error, rather than
+// leaving it blocked on a reply that will never arrive.
+func TestQMPExecuteAfterCloseReturnsError(t *testing.T) {
+	c, server := newTestQMPClient(t)
+
+	// Accept the command, so that execute gets past sending it, but never
+	// reply.
+	go func() {
+		var cmd qmpCommand
+		json.NewDecoder(server).Decode(&cmd)
+	}()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.execute("query-status", nil)
+		done <- err
+	}()
+
+	// Give execute a moment to send its command and start waiting for a
+	// reply, then sever the connection without ever replying.
+	time.Sleep(50 * time.Millisecond)
+	server.Close()
+	c.conn.Close()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("execute() = nil error after connection closed, want an error")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("execute() hung after the connection closed instead of returning an error")
+	}
+}
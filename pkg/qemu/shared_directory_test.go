@@ -0,0 +1,54 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package qemu
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSharedDirectoryCmdline9P(t *testing.T) {
+	s := &SharedDirectory{Dir: "/tmp/share", Tag: "hostshare", ReadOnly: true}
+	want := []string{
+		"-fsdev", "local,id=fs0,path=/tmp/share,security_model=mapped-xattr,readonly=on",
+		"-device", "virtio-9p-pci,fsdev=fs0,mount_tag=hostshare",
+	}
+	if got := s.Cmdline(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Cmdline() = %v, want %v", got, want)
+	}
+}
+
+func TestSharedDirectoryCmdlineVirtioFS(t *testing.T) {
+	s := &SharedDirectory{
+		Dir:        "/tmp/share",
+		Tag:        "hostshare",
+		Transport:  TransportVirtioFS,
+		SocketPath: "/tmp/vfsd.sock",
+	}
+	want := []string{
+		"-chardev", "socket,id=char0,path=/tmp/vfsd.sock",
+		"-device", "vhost-user-fs-pci,queue-size=1024,chardev=char0,tag=hostshare",
+		"-object", "memory-backend-memfd,id=mem,size=1G,share=on",
+		"-numa", "node,memdev=mem",
+	}
+	if got := s.Cmdline(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Cmdline() = %v, want %v", got, want)
+	}
+}
+
+func TestSharedDirectoryMountCmd(t *testing.T) {
+	for _, tt := range []struct {
+		transport Transport
+		want      string
+	}{
+		{Transport9P, "mount -t 9p -o trans=virtio hostshare /mnt"},
+		{TransportVirtioFS, "mount -t virtiofs hostshare /mnt"},
+	} {
+		s := &SharedDirectory{Tag: "hostshare", Transport: tt.transport}
+		if got := s.MountCmd("/mnt"); got != tt.want {
+			t.Errorf("MountCmd() = %q, want %q", got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package qemu
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// writeFakeQEMU writes script to an executable file in a temp directory and
+// returns its path, so tests can exercise VM's Start/Expect/Send/Kill
+// plumbing without a real QEMU binary.
+func writeFakeQEMU(t *testing.T, script string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "fake-qemu.sh")
+	if err := os.WriteFile(path, []byte(script), 0o755); err != nil {
+		t.Fatalf("writing fake QEMU script: %v", err)
+	}
+	return path
+}
+
+func TestVMStartExpectSendKill(t *testing.T) {
+	v := &VM{
+		// exec, so that Kill's SIGKILL reaches the process holding the
+		// console pipes.
+		QEMUPath: writeFakeQEMU(t, "#!/bin/sh\necho ready\nexec cat\n"),
+		Kernel:   "/nonexistent",
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := v.Start(ctx); err != nil {
+		t.Fatalf("Start() = %v", err)
+	}
+
+	if err := v.Expect(regexp.MustCompile("ready"), 2*time.Second); err != nil {
+		t.Fatalf("Expect(ready) = %v", err)
+	}
+
+	if err := v.Send("hello\n"); err != nil {
+		t.Fatalf("Send() = %v", err)
+	}
+	if err := v.Expect(regexp.MustCompile("hello"), 2*time.Second); err != nil {
+		t.Fatalf("Expect(hello) = %v", err)
+	}
+
+	if err := v.Kill(); err != nil {
+		t.Fatalf("Kill() = %v", err)
+	}
+	if err := v.Wait(); err != nil {
+		t.Fatalf("Wait() = %v", err)
+	}
+}
+
+// TestVMKillAfterProcessExited is a regression test: Kill must not try (and
+// fail) to signal a process that has already exited on its own.
+func TestVMKillAfterProcessExited(t *testing.T) {
+	v := &VM{
+		QEMUPath: writeFakeQEMU(t, "#!/bin/sh\nexit 0\n"),
+		Kernel:   "/nonexistent",
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := v.Start(ctx); err != nil {
+		t.Fatalf("Start() = %v", err)
+	}
+	if err := v.Wait(); err != nil {
+		t.Fatalf("Wait() = %v", err)
+	}
+
+	if err := v.Kill(); err != nil {
+		t.Fatalf("Kill() after the process already exited = %v, want nil", err)
+	}
+}